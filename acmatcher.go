@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// matcher abstracts over a compiled regexp and a multi-pattern
+// Aho-Corasick automaton so grepReader can use either without caring
+// which one it was handed.
+type matcher interface {
+	Match(line []byte) bool
+	FindAllIndex(line []byte, n int) [][]int
+}
+
+// regexMatcher adapts *regexp.Regexp to matcher, prefiltering lines with a
+// required literal factor pulled out of the pattern when one exists so
+// grepReader can skip the full regexp engine on lines that can't match.
+type regexMatcher struct {
+	rx      *regexp.Regexp
+	literal []byte // required substring, or nil if none could be derived
+}
+
+func newRegexMatcher(rx *regexp.Regexp) *regexMatcher {
+	m := &regexMatcher{rx: rx}
+	if lit, ok := requiredLiteral(rx.String()); ok {
+		m.literal = []byte(strings.ToLower(lit))
+	}
+	return m
+}
+
+func (m *regexMatcher) Match(line []byte) bool {
+	if m.literal != nil && !bytes.Contains(bytes.ToLower(line), m.literal) {
+		return false
+	}
+	return m.rx.Match(line)
+}
+
+func (m *regexMatcher) FindAllIndex(line []byte, n int) [][]int {
+	return m.rx.FindAllIndex(line, n)
+}
+
+// buildMatcher compiles regexPatterns and literalPatterns into a single
+// matcher. Literal patterns always run through one shared Aho-Corasick
+// automaton; each regex pattern gets its own regexMatcher. When the
+// result reduces to exactly one regex and no literals, that regexp is
+// also returned so callers (e.g. the trigram index) can query on it
+// directly; in every other case singleRx is nil.
+func buildMatcher(regexPatterns, literalPatterns []string) (m matcher, singleRx *regexp.Regexp, err error) {
+	var subs []matcher
+	for _, p := range regexPatterns {
+		rx, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, nil, err
+		}
+		subs = append(subs, newRegexMatcher(rx))
+		if len(regexPatterns) == 1 && len(literalPatterns) == 0 {
+			singleRx = rx
+		}
+	}
+	if len(literalPatterns) > 0 {
+		subs = append(subs, newACMatcher(literalPatterns, true))
+	}
+	switch len(subs) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		return subs[0], singleRx, nil
+	default:
+		return &multiMatcher{subs}, nil, nil
+	}
+}
+
+// requiredLiteral returns the longest literal substring that every match
+// of pattern must contain, derived from the regex AST. It's a best-effort
+// quick-reject filter, not a correctness check, so it bails out (ok=false)
+// rather than guess when the pattern has no such guaranteed substring.
+func requiredLiteral(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	best := longestLiteral(re.Simplify())
+	if len(best) < 3 {
+		return "", false
+	}
+	return best, true
+}
+
+func longestLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCapture:
+		if len(re.Sub) == 1 {
+			return longestLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		best := ""
+		for _, sub := range re.Sub {
+			if s := longestLiteral(sub); len(s) > len(best) {
+				best = s
+			}
+		}
+		return best
+	}
+	return ""
+}
+
+// multiMatcher ORs several matchers together: a line matches if any one of
+// them does, and FindAllIndex reports every sub-matcher's spans merged
+// into left-to-right order. It's how cgrep combines multiple -e regexes
+// and/or a literal-pattern Aho-Corasick automaton into a single pass.
+type multiMatcher struct {
+	sub []matcher
+}
+
+func (mm *multiMatcher) Match(line []byte) bool {
+	for _, s := range mm.sub {
+		if s.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mm *multiMatcher) FindAllIndex(line []byte, n int) [][]int {
+	var out [][]int
+	for _, s := range mm.sub {
+		out = append(out, s.FindAllIndex(line, -1)...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// acNode is one state of the Aho-Corasick trie.
+type acNode struct {
+	children map[byte]*acNode
+	fail     *acNode
+	output   []int // indices into acMatcher.patterns that end at this node
+}
+
+func newACNode() *acNode {
+	return &acNode{children: make(map[byte]*acNode)}
+}
+
+// acMatcher implements matcher by scanning a line against many literal
+// patterns at once in O(n + matches) via an Aho-Corasick automaton: a trie
+// of pattern bytes where each node's failure link points to the longest
+// proper suffix of its path that is also a trie prefix, and output links
+// chain in any shorter pattern that also ends at that node.
+type acMatcher struct {
+	root     *acNode
+	patterns []string
+	fold     bool
+}
+
+// newACMatcher builds an Aho-Corasick automaton over patterns. When fold
+// is true, matching is case-insensitive.
+func newACMatcher(patterns []string, fold bool) *acMatcher {
+	m := &acMatcher{root: newACNode(), patterns: patterns, fold: fold}
+	for i, p := range patterns {
+		if fold {
+			p = strings.ToLower(p)
+		}
+		n := m.root
+		for j := 0; j < len(p); j++ {
+			c := p[j]
+			child, ok := n.children[c]
+			if !ok {
+				child = newACNode()
+				n.children[c] = child
+			}
+			n = child
+		}
+		n.output = append(n.output, i)
+	}
+	m.buildFailureLinks()
+	return m
+}
+
+func (m *acMatcher) buildFailureLinks() {
+	queue := make([]*acNode, 0, len(m.root.children))
+	for _, child := range m.root.children {
+		child.fail = m.root
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for c, child := range n.children {
+			queue = append(queue, child)
+
+			fail := n.fail
+			for fail != nil {
+				if next, ok := fail.children[c]; ok {
+					child.fail = next
+					break
+				}
+				fail = fail.fail
+			}
+			if child.fail == nil {
+				child.fail = m.root
+			}
+			child.output = append(child.output, child.fail.output...)
+		}
+	}
+}
+
+func (m *acMatcher) Match(line []byte) bool {
+	return len(m.FindAllIndex(line, 1)) > 0
+}
+
+// FindAllIndex scans line once and returns the spans of every pattern
+// occurrence, in the order they end, mirroring regexp.FindAllIndex's
+// [][]int{start, end} shape so callers can treat the two matchers alike.
+func (m *acMatcher) FindAllIndex(line []byte, n int) [][]int {
+	if m.fold {
+		line = bytes.ToLower(line)
+	}
+	var out [][]int
+	node := m.root
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		for node != m.root {
+			if _, ok := node.children[c]; ok {
+				break
+			}
+			node = node.fail
+		}
+		if child, ok := node.children[c]; ok {
+			node = child
+		}
+		for _, pi := range node.output {
+			start := i + 1 - len(m.patterns[pi])
+			out = append(out, []int{start, i + 1})
+			if n > 0 && len(out) >= n {
+				return out
+			}
+		}
+	}
+	return out
+}
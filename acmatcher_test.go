@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// The classic overlapping-pattern stress case for Aho-Corasick: "she",
+// "he", "his" and "hers" over "ushers" exercises failure links correctly
+// only if "he" (reported at the "she" node through its failure link's
+// output) and "hers" (reached via that link once "she" fails to continue)
+// both fire alongside "she".
+func TestACMatcherOverlappingPatterns(t *testing.T) {
+	m := newACMatcher([]string{"he", "she", "his", "hers"}, false)
+
+	got := m.FindAllIndex([]byte("ushers"), -1)
+	want := [][]int{{1, 4}, {2, 4}, {2, 6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindAllIndex(%q) = %v, want %v", "ushers", got, want)
+	}
+}
+
+func TestACMatcherCaseFold(t *testing.T) {
+	m := newACMatcher([]string{"she", "hers"}, true)
+
+	if !m.Match([]byte("USHERS")) {
+		t.Fatalf("case-folded matcher should match %q", "USHERS")
+	}
+	if m2 := newACMatcher([]string{"she", "hers"}, false); m2.Match([]byte("USHERS")) {
+		t.Fatalf("non-folded matcher should not match %q", "USHERS")
+	}
+}
+
+func TestACMatcherNoMatch(t *testing.T) {
+	m := newACMatcher([]string{"foo", "bar"}, false)
+	if m.Match([]byte("a line with neither pattern")) {
+		t.Fatal("expected no match")
+	}
+}
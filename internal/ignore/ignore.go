@@ -0,0 +1,227 @@
+// Package ignore implements gitignore-style pattern matching so cgrep can
+// skip vendored/build directories the way git, grep --exclude-dir and
+// friends do, without hard-coding a fixed list of directory names.
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFiles are the filenames read from each directory while walking,
+// in order, cgrep's own .cgrepignore taking the same precedence as the
+// others (later rules in a directory's combined rule set win).
+var IgnoreFiles = []string{".gitignore", ".ignore", ".cgrepignore"}
+
+// rule is one compiled gitignore pattern.
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Set is the rules contributed by the ignore files (and/or CLI globs)
+// found in a single directory, scoped to that directory.
+type Set struct {
+	base  string
+	rules []rule
+}
+
+// NewSet compiles patterns (one per gitignore line, in file order) scoped
+// to base. It's exported so CLI-supplied --glob patterns can be compiled
+// into a Set the same way file-sourced ones are.
+func NewSet(base string, patterns []string) (*Set, error) {
+	s := &Set{base: filepath.Clean(base)}
+	for _, p := range patterns {
+		r, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			s.rules = append(s.rules, *r)
+		}
+	}
+	return s, nil
+}
+
+// LoadDir reads every recognized ignore file present in dir and returns
+// the combined Set scoped to dir, or nil if dir has none.
+func LoadDir(dir string) (*Set, error) {
+	var patterns []string
+	for _, name := range IgnoreFiles {
+		lines, err := readLines(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, lines...)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return NewSet(dir, patterns)
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// matches reports whether path (relative to s.base, slash-separated) is
+// matched by the rule's regexp, honoring the dirOnly qualifier.
+func (r rule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.re.MatchString(relPath)
+}
+
+// Stack is the sequence of Sets scoped to each directory from the search
+// root down to the directory currently being walked; deeper Sets take
+// precedence, matching git's own "closer ignore file wins" semantics.
+type Stack []*Set
+
+// Push returns a new Stack with set appended. set may be nil, in which
+// case the returned Stack is equivalent to st (Push is still cheap and
+// safe to call unconditionally from the walker).
+func (st Stack) Push(set *Set) Stack {
+	if set == nil {
+		return st
+	}
+	next := make(Stack, len(st)+1)
+	copy(next, st)
+	next[len(st)] = set
+	return next
+}
+
+// Ignored reports whether path should be skipped. Sets are consulted
+// root-to-leaf; within and across Sets the last matching rule wins,
+// letting a deeper or later "!pattern" re-include something an earlier
+// rule excluded.
+func (st Stack) Ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, s := range st {
+		rel, err := filepath.Rel(s.base, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, r := range s.rules {
+			if r.matches(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// compile translates one gitignore-syntax line into a rule. It returns a
+// nil rule (and nil error) for lines that, once trimmed, carry no pattern.
+func compile(pattern string) (*rule, error) {
+	negate := false
+	if strings.HasPrefix(pattern, "!") {
+		negate = true
+		pattern = pattern[1:]
+	}
+	pattern = strings.TrimRight(pattern, " ")
+	if pattern == "" {
+		return nil, nil
+	}
+
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	anchored = anchored || strings.Contains(pattern, "/")
+
+	re, err := regexp.Compile("^" + globToRegexp(pattern, anchored) + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &rule{re: re, negate: negate, dirOnly: dirOnly}, nil
+}
+
+// globToRegexp turns a gitignore glob into an equivalent regexp fragment.
+// When anchored is false the pattern may match starting at any path
+// segment, mirroring gitignore's rule that a pattern without a slash
+// matches at any depth.
+func globToRegexp(pattern string, anchored bool) string {
+	segs := strings.Split(pattern, "/")
+	var out strings.Builder
+	needSep := false
+	for i, seg := range segs {
+		if seg == "**" && i < len(segs)-1 {
+			// A "**" that isn't the last segment matches zero or more
+			// whole path segments, slashes included, so "a/**/b" matches
+			// "a/b" as well as "a/x/b" and "**/foo" matches top-level
+			// "foo" as well as "a/foo".
+			if needSep {
+				out.WriteByte('/')
+			}
+			out.WriteString("(?:.*/)?")
+			needSep = false
+			continue
+		}
+		if needSep {
+			out.WriteByte('/')
+		}
+		if seg == "**" {
+			out.WriteString(".*")
+		} else {
+			out.WriteString(segToRegexp(seg))
+		}
+		needSep = true
+	}
+	if anchored {
+		return out.String()
+	}
+	return "(.*/)?" + out.String()
+}
+
+func segToRegexp(seg string) string {
+	var out strings.Builder
+	for i := 0; i < len(seg); i++ {
+		c := seg[i]
+		switch c {
+		case '*':
+			out.WriteString("[^/]*")
+		case '?':
+			out.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			for j < len(seg) && seg[j] != ']' {
+				j++
+			}
+			if j < len(seg) {
+				class := seg[i : j+1]
+				out.WriteString(strings.Replace(class, "!", "^", 1))
+				i = j
+			} else {
+				out.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		default:
+			out.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return out.String()
+}
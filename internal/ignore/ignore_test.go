@@ -0,0 +1,34 @@
+package ignore
+
+import "testing"
+
+// Gitignore's "**" matches zero or more whole path segments, including
+// the zero-segment case, per the documented semantics this package
+// claims to implement.
+func TestDoubleStarZeroSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "a/c", false},
+		{"**/foo", "foo", true},
+		{"**/foo", "a/foo", true},
+		{"**/foo", "a/b/foo", true},
+		{"**/foo", "bar", false},
+	}
+	for _, tt := range tests {
+		s, err := NewSet("/root", []string{tt.pattern})
+		if err != nil {
+			t.Fatalf("NewSet(%q): %v", tt.pattern, err)
+		}
+		st := Stack{s}
+		got := st.Ignored("/root/"+tt.path, false)
+		if got != tt.want {
+			t.Errorf("pattern %q, path %q: Ignored = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package index implements a trigram-based inverted index over a directory
+// tree, in the style of Russ Cox's csearch/codesearch. It lets cgrep answer
+// a regex query by first intersecting posting lists for the trigrams the
+// regex requires, producing a small candidate file set that the real regex
+// only needs to be run against.
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"os"
+	"sort"
+	"time"
+)
+
+// FileID identifies a file within an Index. IDs are stable for the
+// lifetime of the index; a file re-added by AddFile keeps its ID.
+type FileID uint32
+
+// Trigram is three consecutive bytes packed into the low 24 bits of a
+// uint32, used as the posting list key.
+type Trigram uint32
+
+// TrigramOf packs three bytes into a Trigram.
+func TrigramOf(a, b, c byte) Trigram {
+	return Trigram(a)<<16 | Trigram(b)<<8 | Trigram(c)
+}
+
+type fileEntry struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// onDisk is the gob-serializable representation of an Index.
+type onDisk struct {
+	Files    []fileEntry
+	Postings map[Trigram][]FileID
+}
+
+// Index is an in-memory trigram index that can be persisted to disk.
+type Index struct {
+	path     string
+	files    []fileEntry
+	postings map[Trigram][]FileID
+	byPath   map[string]FileID
+}
+
+// Create returns a new, empty Index that will be written to path on Save.
+// It does not touch disk until Save is called.
+func Create(path string) (*Index, error) {
+	return &Index{
+		path:     path,
+		postings: make(map[Trigram][]FileID),
+		byPath:   make(map[string]FileID),
+	}, nil
+}
+
+// Open loads an existing index previously written by Save.
+func Open(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var d onDisk
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		path:     path,
+		files:    d.Files,
+		postings: d.Postings,
+		byPath:   make(map[string]FileID, len(d.Files)),
+	}
+	if idx.postings == nil {
+		idx.postings = make(map[Trigram][]FileID)
+	}
+	for id, fe := range idx.files {
+		idx.byPath[fe.Path] = FileID(id)
+	}
+	return idx, nil
+}
+
+// Save persists the index to its on-disk path, overwriting any existing
+// file there.
+func (idx *Index) Save() error {
+	f, err := os.Create(idx.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	d := onDisk{Files: idx.files, Postings: idx.postings}
+	if err := gob.NewEncoder(w).Encode(&d); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// AddFile reads path and folds its trigrams into the index. If path is
+// already indexed with the same mtime and size, it is skipped so that
+// -reindex only rescans files that actually changed. It reports whether
+// the file was (re)scanned.
+func (idx *Index) AddFile(path string, info os.FileInfo) (bool, error) {
+	if id, ok := idx.byPath[path]; ok {
+		fe := idx.files[id]
+		if fe.ModTime.Equal(info.ModTime()) && fe.Size == info.Size() {
+			return false, nil
+		}
+		idx.removeFromPostings(id)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	id, ok := idx.byPath[path]
+	if !ok {
+		id = FileID(len(idx.files))
+		idx.files = append(idx.files, fileEntry{})
+		idx.byPath[path] = id
+	}
+	idx.files[id] = fileEntry{Path: path, ModTime: info.ModTime(), Size: info.Size()}
+
+	for t := range trigramsOf(data) {
+		idx.addPosting(t, id)
+	}
+	return true, nil
+}
+
+func (idx *Index) addPosting(t Trigram, id FileID) {
+	list := idx.postings[t]
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= id })
+	if i < len(list) && list[i] == id {
+		return
+	}
+	list = append(list, 0)
+	copy(list[i+1:], list[i:])
+	list[i] = id
+	idx.postings[t] = list
+}
+
+func (idx *Index) removeFromPostings(id FileID) {
+	for t, list := range idx.postings {
+		i := sort.Search(len(list), func(i int) bool { return list[i] >= id })
+		if i < len(list) && list[i] == id {
+			idx.postings[t] = append(list[:i], list[i+1:]...)
+		}
+	}
+}
+
+// trigramsOf returns the set of trigrams present in data. Bytes are
+// lowercased first so the index lines up with RequiredTrigrams, which
+// folds case the same way: every cgrep pattern is compiled with a forced
+// (?i), so the query side only ever knows about case-folded trigrams.
+func trigramsOf(data []byte) map[Trigram]bool {
+	set := make(map[Trigram]bool)
+	if len(data) < 3 {
+		return set
+	}
+	lower := bytes.ToLower(data)
+	for i := 0; i+3 <= len(lower); i++ {
+		set[TrigramOf(lower[i], lower[i+1], lower[i+2])] = true
+	}
+	return set
+}
+
+// PostingQuery evaluates query, a conjunction of disjunctions of required
+// trigrams (as produced by RequiredTrigrams), and returns the matching
+// file paths in sorted order. An empty query matches every indexed file.
+func (idx *Index) PostingQuery(query [][]Trigram) []string {
+	var candidates []FileID
+	matched := false
+
+	for _, group := range query {
+		union := idx.union(group)
+		if !matched {
+			candidates = union
+			matched = true
+			continue
+		}
+		candidates = intersect(candidates, union)
+	}
+
+	if !matched {
+		candidates = make([]FileID, len(idx.files))
+		for i := range idx.files {
+			candidates[i] = FileID(i)
+		}
+	}
+
+	paths := make([]string, 0, len(candidates))
+	for _, id := range candidates {
+		paths = append(paths, idx.files[id].Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (idx *Index) union(trigrams []Trigram) []FileID {
+	seen := make(map[FileID]bool)
+	var out []FileID
+	for _, t := range trigrams {
+		for _, id := range idx.postings[t] {
+			if !seen[id] {
+				seen[id] = true
+				out = append(out, id)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func intersect(a, b []FileID) []FileID {
+	var out []FileID
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,169 @@
+package index
+
+import (
+	"regexp/syntax"
+	"unicode"
+)
+
+// RequiredTrigrams reduces pattern to a trigram query: a conjunction of
+// disjunctions of Trigrams, suitable for Index.PostingQuery. Concatenated
+// literals contribute every overlapping required trigram (a 6-byte
+// literal contributes 4 groups, one per sliding window, not just its two
+// non-overlapping halves), alternations contribute a union, and
+// character classes contribute a union per position. Sub-expressions
+// that can't be reduced with confidence (stars, quantified repetition,
+// dot, anchors) are treated as "matches anything" and simply contribute
+// nothing to the query, same as codesearch does.
+func RequiredTrigrams(pattern string) ([][]Trigram, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	q := build(re.Simplify(), query{})
+	return q.groups, nil
+}
+
+// query threads the trigrams a regexp is known to require through the
+// recursive walk of its AST, as a sliding window of the last (up to 2)
+// positions still being accumulated into a literal run (exact) plus the
+// AND-of-OR groups already finalized (groups).
+type query struct {
+	exact  [][]rune // cross product of the last up-to-2 positions, not yet 3 long
+	groups [][]Trigram
+}
+
+// build walks re, threading q's sliding window and groups across
+// sibling sub-expressions (e.g. a literal immediately followed by a
+// character class) so that trigrams spanning the boundary between them
+// are still found, then returns the updated query.
+func build(re *syntax.Regexp, q query) query {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			q = q.append([]rune{unicode.ToLower(r)})
+		}
+		return q
+	case syntax.OpCharClass:
+		return q.append(runesOf(re.Rune))
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			q = build(sub, q)
+		}
+		return q
+	case syntax.OpCapture:
+		if len(re.Sub) == 1 {
+			return build(re.Sub[0], q)
+		}
+		return q.append(nil)
+	case syntax.OpAlternate:
+		// Any match of an alternative satisfies that alternative's first
+		// required group, so the union of every alternative's first group
+		// is required; an alternative with no group makes it unknowable.
+		var union []Trigram
+		seen := make(map[Trigram]bool)
+		allRequire := true
+		for _, sub := range re.Sub {
+			sq := build(sub, query{})
+			if len(sq.groups) == 0 {
+				allRequire = false
+				break
+			}
+			for _, t := range sq.groups[0] {
+				if !seen[t] {
+					seen[t] = true
+					union = append(union, t)
+				}
+			}
+		}
+		if allRequire && len(union) > 0 {
+			q.groups = append(q.groups, union)
+		}
+		return q.append(nil)
+	default:
+		// Star, Plus, Quest, AnyChar, anchors, etc: no required trigram,
+		// and it breaks the sliding window since what's at this position
+		// isn't known.
+		return q.append(nil)
+	}
+}
+
+func runesOf(ranges []rune) []rune {
+	var out []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for r := ranges[i]; r <= ranges[i+1]; r++ {
+			out = append(out, unicode.ToLower(r))
+			if len(out) > 64 {
+				// Charclass too wide to be a useful trigram constraint.
+				return nil
+			}
+		}
+	}
+	return out
+}
+
+// append folds one more position's rune choices into the sliding window
+// of up to 2 pending positions. Once the window reaches length 3 it's
+// flushed as a newly-required group, and the window slides forward by
+// dropping its oldest position (rather than resetting to empty), so a
+// literal run of any length contributes every overlapping trigram: "abc"
+// followed by "def" yields abc, bcd, cde, def, not just abc and def.
+func (q query) append(choices []rune) query {
+	if choices == nil {
+		// Unbounded/too-wide position: breaks the sliding window, but
+		// keeps whatever groups have already been finalized.
+		return query{groups: q.groups}
+	}
+	var next [][]rune
+	if len(q.exact) == 0 {
+		for _, r := range choices {
+			next = append(next, []rune{r})
+		}
+	} else {
+		for _, prefix := range q.exact {
+			for _, r := range choices {
+				p := append(append([]rune{}, prefix...), r)
+				next = append(next, p)
+			}
+		}
+	}
+	if len(next) > 0 && len(next[0]) == 3 {
+		q.groups = append(q.groups, exactTrigrams(next))
+		next = slideWindow(next)
+	}
+	q.exact = next
+	return q
+}
+
+// slideWindow drops the oldest position from each completed length-3
+// row, leaving the last 2 positions as the starting point for the next
+// window, and dedupes rows that collapse onto the same tail (e.g. after
+// a character class fanned a row out into several).
+func slideWindow(rows [][]rune) [][]rune {
+	seen := make(map[string]bool)
+	var out [][]rune
+	for _, row := range rows {
+		tail := row[1:]
+		key := string(tail)
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, tail)
+		}
+	}
+	return out
+}
+
+func exactTrigrams(runs [][]rune) []Trigram {
+	seen := make(map[Trigram]bool)
+	var out []Trigram
+	for _, run := range runs {
+		if len(run) != 3 {
+			continue
+		}
+		t := TrigramOf(byte(run[0]), byte(run[1]), byte(run[2]))
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cgrep always compiles patterns with a forced (?i) prefix (see
+// buildMatcher in main.go), so RequiredTrigrams must be fed the already
+// case-folded regexp string, and the trigrams it produces must line up
+// with the case-folded bytes trigramsOf indexes. Exercise both sides
+// together the way handleIndexedGrep does: derive the query from a
+// (?i)-prefixed pattern and check it matches an index built from lowercase
+// source.
+func TestRequiredTrigramsCaseFold(t *testing.T) {
+	idx := indexOf(t, "fake.txt", "hello world")
+
+	query, err := RequiredTrigrams("(?i)hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query) == 0 {
+		t.Fatalf("RequiredTrigrams(%q) produced no groups", "(?i)hello")
+	}
+	got := idx.PostingQuery(query)
+	if len(got) != 1 || filepath.Base(got[0]) != "fake.txt" {
+		t.Fatalf("PostingQuery(%v) = %v, want [fake.txt]", query, got)
+	}
+}
+
+func TestRequiredTrigramsCharClassFold(t *testing.T) {
+	idx := indexOf(t, "fake.txt", "needle in a haystack")
+
+	query, err := RequiredTrigrams("(?i)[nN]eedle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := idx.PostingQuery(query)
+	if len(got) != 1 || filepath.Base(got[0]) != "fake.txt" {
+		t.Fatalf("PostingQuery(%v) = %v, want [fake.txt]", query, got)
+	}
+}
+
+// A literal adjacent to a character class (or any other multi-position
+// sub-expression) must merge into the running sliding window, not have
+// its whole accumulated prefix reinterpreted as a single position's
+// alternatives. "ab[cd]ef" requires exactly the windows abc/abd,
+// bce/bde, cef/def and nothing else.
+func TestRequiredTrigramsAcrossCharClassBoundary(t *testing.T) {
+	query, err := RequiredTrigrams("(?i)ab[cd]ef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"abc", "abd"},
+		{"bce", "bde"},
+		{"cef", "def"},
+	}
+	if len(query) != len(want) {
+		t.Fatalf("RequiredTrigrams(\"ab[cd]ef\") = %d groups, want %d: %v", len(query), len(want), trigramStrings(query))
+	}
+	for i, group := range query {
+		if !sameSet(trigramStrings([][]Trigram{group})[0], want[i]) {
+			t.Errorf("group %d = %v, want %v", i, trigramStrings([][]Trigram{group})[0], want[i])
+		}
+	}
+}
+
+// A literal longer than 3 bytes must contribute every overlapping
+// trigram, not just its non-overlapping halves.
+func TestRequiredTrigramsSlidingWindow(t *testing.T) {
+	query, err := RequiredTrigrams("(?i)abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"abc", "bcd", "cde", "def"}
+	if len(query) != len(want) {
+		t.Fatalf("RequiredTrigrams(\"abcdef\") = %d groups, want %d: %v", len(query), len(want), trigramStrings(query))
+	}
+	for i, group := range query {
+		got := trigramStrings([][]Trigram{group})[0]
+		if len(got) != 1 || got[0] != want[i] {
+			t.Errorf("group %d = %v, want [%s]", i, got, want[i])
+		}
+	}
+}
+
+func trigramStrings(groups [][]Trigram) [][]string {
+	out := make([][]string, len(groups))
+	for i, g := range groups {
+		s := make([]string, len(g))
+		for j, t := range g {
+			s[j] = string([]byte{byte(t >> 16), byte(t >> 8), byte(t)})
+		}
+		out[i] = s
+	}
+	return out
+}
+
+func sameSet(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := make(map[string]bool, len(got))
+	for _, s := range got {
+		seen[s] = true
+	}
+	for _, s := range want {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// indexOf writes content to name inside a temp dir and returns an Index
+// containing just that file.
+func indexOf(t *testing.T, name, content string) *Index {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Create(filepath.Join(dir, "test.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.AddFile(path, info); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
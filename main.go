@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
@@ -10,16 +11,18 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
-	"sync"
-	"time"
+	"strings"
 
 	"github.com/logrusorgru/aurora"
+	"github.com/rednexela1941/cgrep/internal/ignore"
+	"github.com/rednexela1941/cgrep/internal/index"
 )
 
 const (
-	fileLimit      uint  = 1024
-	allocBufferLen uint  = 64
-	longFileLim    int64 = 5 * 1024 * 1024
+	fileLimit           uint  = 1024
+	allocBufferLen      uint  = 64
+	allocBufferLenBytes int   = 64 * 1024
+	longFileLim         int64 = 5 * 1024 * 1024
 )
 
 var colors aurora.Aurora
@@ -31,21 +34,121 @@ var (
 	color   = flag.Bool("color", false, "enable colored output")
 	dir     = flag.String("dir", "", "starting directory path")
 	help    = flag.Bool("h", false, "help")
+
+	indexPath = flag.String("index", "", "path to a trigram index used to speed up searches over this tree")
+	reindex   = flag.Bool("reindex", false, "(re)build the trigram index at -index before searching")
+
+	workers = flag.Int("j", 0, "number of worker goroutines to search with (default: number of CPUs)")
+
+	fixedString   = flag.Bool("F", false, "interpret all patterns as fixed strings, not regular expressions")
+	extraPatterns stringSliceFlag
+	fixedPatterns stringSliceFlag
+
+	globs      stringSliceFlag
+	includeExt = flag.String("include-ext", "", "comma-separated list of file extensions to search, excluding all others")
+	excludeExt = flag.String("exclude-ext", "", "comma-separated list of file extensions to skip")
+
+	binaryMode        = flag.String("binary", "print", "how to handle files that look binary: skip|print|text (mirrors grep's -I/-a; print emits a one-line \"binary file matches\" summary instead of content)")
+	maxLineLength     = flag.Int("max-line-length", 4096, "lines longer than this many bytes are truncated before matching")
+	maxColumnsPreview = flag.Bool("max-columns-preview", false, "for lines beyond --max-line-length, print a match count instead of a truncated preview")
+
+	output = flag.String("output", "", "output format: (default) colored human-readable, or grep|json|jsonl|null")
+)
+
+const (
+	sniffLen                  = 8 * 1024
+	nonPrintableBinaryPortion = 0.30
 )
 
+// isBinary sniffs the first bytes of a file the way grep's -I/-a detection
+// does: a NUL byte means binary outright, and otherwise a file is treated
+// as binary once more than nonPrintableBinaryPortion of the sample is
+// non-printable.
+func isBinary(sample []byte) bool {
+	if len(sample) == 0 {
+		return false
+	}
+	nonPrintable := 0
+	for _, b := range sample {
+		switch {
+		case b == 0:
+			return true
+		case b == '\n' || b == '\r' || b == '\t':
+		case b < 0x20 || b == 0x7f:
+			nonPrintable++
+		}
+	}
+	return float64(nonPrintable)/float64(len(sample)) > nonPrintableBinaryPortion
+}
+
+func init() {
+	flag.Var(&extraPatterns, "e", "pattern to search for, in addition to the positional pattern (may be repeated)")
+	flag.Var(&fixedPatterns, "Q", "fixed-string pattern to search for, in addition to other patterns (may be repeated)")
+	flag.Var(&globs, "glob", "gitignore-style glob of paths to skip (prefixed with !, re-includes a path an earlier pattern skipped); may be repeated")
+	flag.Var(&globs, "g", "shorthand for -glob")
+}
+
+// defaultIgnorePatterns are always in effect, independent of any
+// .gitignore/.ignore/.cgrepignore file, since cgrep should never need to
+// be told twice not to search git's own metadata directory.
+var defaultIgnorePatterns = []string{".git/"}
+
+// stringSliceFlag implements flag.Value to let -e/-Q be passed more than
+// once, each occurrence appending to the slice.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 var (
 	whiteSpace   = regexp.MustCompile("[\\s]+")
 	leadingSpace = regexp.MustCompile("^[\\s]+")
-	ignorePath   = regexp.MustCompile("(.git|node_modules)$")
-	tooManyOpen  = regexp.MustCompile("too many open files")
 )
 
+// extSet turns a comma-separated --include-ext/--exclude-ext value into a
+// lookup set of lowercased extensions, without their leading dot.
+type extSet map[string]bool
+
+func newExtSet(csv string) extSet {
+	if len(csv) == 0 {
+		return nil
+	}
+	set := make(extSet)
+	for _, e := range strings.Split(csv, ",") {
+		e = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), "."))
+		if e != "" {
+			set[e] = true
+		}
+	}
+	return set
+}
+
+func (s extSet) has(path string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	return s[ext]
+}
+
+// extAllowed reports whether path passes the --include-ext/--exclude-ext
+// filters.
+func extAllowed(path string, include, exclude extSet) bool {
+	if exclude != nil && exclude.has(path) {
+		return false
+	}
+	if include != nil && !include.has(path) {
+		return false
+	}
+	return true
+}
+
 func main() {
 	var err error
 	var fprx *regexp.Regexp
-	var rx *regexp.Regexp
-	plock := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
 
 	flag.Parse()
 	outStat, err := os.Stdout.Stat()
@@ -73,20 +176,37 @@ func main() {
 		}
 	}
 
+	var regexPatterns, literalPatterns []string
 	args := flag.Args()
-	if len(args) == 0 {
+	if len(args) != 0 {
+		regexPatterns = append(regexPatterns, args[0])
+	}
+	regexPatterns = append(regexPatterns, extraPatterns...)
+	literalPatterns = append(literalPatterns, fixedPatterns...)
+	if *fixedString {
+		literalPatterns = append(literalPatterns, regexPatterns...)
+		regexPatterns = nil
+	}
+
+	if len(regexPatterns) == 0 && len(literalPatterns) == 0 {
 		if fprx == nil || isPipe {
 			log.Fatal("no arguments provided")
 		}
-	} else {
-		rx, err = regexp.Compile("(?i)" + args[0])
-		if err != nil {
-			log.Fatalf("invalid regex %s", err.Error())
-		}
+	}
+
+	m, rx, err := buildMatcher(regexPatterns, literalPatterns)
+	if err != nil {
+		log.Fatalf("invalid pattern %s", err.Error())
+	}
+
+	sink, err := newOutputSink(*output)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if isPipe {
-		grepReader("STDIN", os.Stdin, rx, plock)
+		grepReader("STDIN", os.Stdin, m, sink, false)
+		sink.Close()
 		return
 	}
 
@@ -98,64 +218,171 @@ func main() {
 		}
 	}
 
-	sem := make(chan struct{}, fileLimit)
-	wg.Add(1)
-	handleGrep(root, rx, fprx, wg, plock, sem)
-	wg.Wait()
-}
+	incExt, excExt := newExtSet(*includeExt), newExtSet(*excludeExt)
 
-func handleGrep(root string, rx, fprx *regexp.Regexp, wg *sync.WaitGroup, plock *sync.Mutex, sem chan struct{}) error {
-	defer wg.Done()
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	defaultSet, err := ignore.NewSet(root, defaultIgnorePatterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stack := ignore.Stack{}.Push(defaultSet)
+	if len(globs) != 0 {
+		globSet, err := ignore.NewSet(root, globs)
 		if err != nil {
+			log.Fatalf("invalid -glob pattern: %s", err.Error())
+		}
+		stack = stack.Push(globSet)
+	}
+	rootSet, err := ignore.LoadDir(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stack = stack.Push(rootSet)
+
+	p := newPool(*workers, openFileUlimit(int(fileLimit)), m, fprx, sink, incExt, excExt)
+
+	if len(*indexPath) != 0 {
+		if err := handleIndexedGrep(*indexPath, root, rx, stack, incExt, excExt, p); err != nil {
 			log.Fatal(err)
 		}
+		p.Close()
+		sink.Close()
+		return
+	}
+
+	p.Walk(root, stack)
+	p.Close()
+	sink.Close()
+}
+
+// handleIndexedGrep builds or loads the trigram index at idxPath and only
+// searches the resulting candidate files instead of walking the whole
+// tree. rx, when non-nil, is the single regexp backing p's matcher and is
+// reduced to a trigram query to narrow those candidates; when rx is nil
+// (several patterns, any fixed-string pattern, or no content pattern) the
+// index falls back to every indexed file.
+func handleIndexedGrep(idxPath, root string, rx *regexp.Regexp, stack ignore.Stack, incExt, excExt extSet, p *pool) error {
+	var idx *index.Index
+	var err error
+
+	if *reindex {
+		// Start from the existing index when there is one, so AddFile
+		// skips files whose mtime and size are unchanged.
+		idx, err = index.Open(idxPath)
+		if err != nil {
+			idx, err = index.Create(idxPath)
+			if err != nil {
+				return err
+			}
+		}
+		if err := buildIndex(idx, root, stack, incExt, excExt); err != nil {
+			return err
+		}
+		if err := idx.Save(); err != nil {
+			return err
+		}
+	} else {
+		idx, err = index.Open(idxPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var query [][]index.Trigram
+	if rx != nil {
+		query, err = index.RequiredTrigrams(rx.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	p.SearchFiles(idx.PostingQuery(query))
+	return nil
+}
+
+// buildIndex walks root adding every regular, non-ignored file to idx.
+func buildIndex(idx *index.Index, root string, stack ignore.Stack, incExt, excExt extSet) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() && path != root {
-			if ignorePath.MatchString(path) {
+			dirSet, err := ignore.LoadDir(path)
+			if err != nil {
+				return err
+			}
+			dirStack := stack.Push(dirSet)
+			if dirStack.Ignored(path, true) {
 				return filepath.SkipDir
 			}
-			wg.Add(1)
-			go handleGrep(path, rx, fprx, wg, plock, sem)
+			if err := buildIndex(idx, path, dirStack, incExt, excExt); err != nil {
+				return err
+			}
 			return filepath.SkipDir
 		}
 		if info.Mode().IsRegular() {
-			if !*long && info.Size() > longFileLim && (fprx == nil || fprx.MatchString(path)) {
-				fmt.Printf("skipping large file %s\n", path)
+			if stack.Ignored(path, false) || !extAllowed(path, incExt, excExt) {
 				return nil
 			}
-			wg.Add(1)
-			go searchFile(path, rx, fprx, wg, plock, sem)
+			if _, err := idx.AddFile(path, info); err != nil {
+				log.Println(err)
+			}
 		}
 		return nil
 	})
-	return err
 }
 
-func grepReader(path string, reader io.Reader, rx *regexp.Regexp, plock *sync.Mutex) {
-	r := bufio.NewReader(reader)
+func grepReader(path string, reader io.Reader, m matcher, sink OutputSink, binarySummary bool) {
+	r := bufio.NewReaderSize(reader, allocBufferLenBytes)
 	linenum := 0
-	lines := make([]string, 0, allocBufferLen)
+	records := make([]matchRecord, 0, allocBufferLen)
 
 	for {
-		l, err := r.ReadBytes('\n')
-		if err != nil {
+		l, truncated, err := readBoundedLine(r, *maxLineLength)
+		if len(l) == 0 && err != nil {
 			if err != io.EOF {
 				log.Println(err)
 			}
 			break
 		}
 		linenum++
-		if rx.Match(l) {
+
+		if truncated {
+			if m.Match(l) {
+				if binarySummary {
+					records = append(records, matchRecord{Path: path, Preview: fmt.Sprintf("binary file %s matches", path), binary: true})
+					break
+				}
+				if *maxColumnsPreview {
+					n := len(m.FindAllIndex(l, -1))
+					match := fmt.Sprintf("%d matches", n)
+					records = append(records, matchRecord{Path: path, Line: linenum, Match: match, Preview: match + " (line too long to preview)"})
+				} else {
+					text := string(l)
+					records = append(records, matchRecord{Path: path, Line: linenum, Col: 1, Match: text, Preview: text + " …[line truncated]"})
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Println(err)
+				}
+				break
+			}
+			continue
+		}
+
+		if m.Match(l) {
+			if binarySummary {
+				records = append(records, matchRecord{Path: path, Preview: fmt.Sprintf("binary file %s matches", path), binary: true})
+				break
+			}
 			l = whiteSpace.ReplaceAll(leadingSpace.ReplaceAll(l, []byte("")), []byte(" "))
-			ms := rx.FindAllIndex(l, -1)
-			lm := len(ms)
+			ms := m.FindAllIndex(l, -1)
 			ll := len(l)
-			oleft := 0
-			lastnl := 0
 
-			for i, m := range ms {
-				left, right := m[0], m[1]
-				if left > oleft+80 {
+			for _, mi := range ms {
+				left, right := mi[0], mi[1]
+				oleft := 0
+				if left > 80 {
 					oleft = left - 10
 				}
 				rightLim := oleft + 80
@@ -165,71 +392,105 @@ func grepReader(path string, reader io.Reader, rx *regexp.Regexp, plock *sync.Mu
 				if rightLim > ll {
 					rightLim = ll
 				}
-				if i+1 < lm {
-					nextl := ms[i+1][0]
-					if nextl < rightLim {
-						rightLim = nextl
-					}
-				}
-				b := formatLine(l[oleft:rightLim], left-oleft, right-oleft, linenum, i)
-				oleft = rightLim
-				if oleft > lastnl+80 || i+1 == lm {
-					b += "\n"
-				}
-				lines = append(lines, b)
+				records = append(records, matchRecord{
+					Path:          path,
+					Line:          linenum,
+					Col:           left + 1,
+					Match:         string(l[left:right]),
+					Preview:       string(l[oleft:rightLim]),
+					previewOffset: left - oleft,
+				})
 			}
 		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			break
+		}
+	}
+
+	if len(records) > 0 {
+		sink.WriteFile(path, records)
 	}
-	if ln := len(lines); ln > 0 {
-		plock.Lock()
-		defer plock.Unlock()
-		fmt.Print(formatHeader(path, ln))
-		for _, l := range lines {
-			fmt.Print(l)
+}
+
+// readBoundedLine reads one newline-terminated line from r, but stops
+// copying into the returned slice once it has accumulated max bytes; any
+// remaining bytes up to the next '\n' are still consumed from r (so the
+// stream stays in sync for the next call) but discarded, and truncated
+// is reported true. This keeps a single pathological (e.g. minified)
+// line from blowing up memory or the cost of running the matcher over it.
+func readBoundedLine(r *bufio.Reader, max int) (line []byte, truncated bool, err error) {
+	for {
+		chunk, e := r.ReadSlice('\n')
+		if !truncated {
+			if len(line)+len(bytes.TrimSuffix(chunk, []byte{'\n'})) > max {
+				if room := max - len(line); room > 0 {
+					line = append(line, chunk[:room]...)
+				}
+				truncated = true
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if e == bufio.ErrBufferFull {
+			continue
 		}
+		return line, truncated, e
 	}
-	lines = nil
 }
 
-func searchFile(path string, rx, fprx *regexp.Regexp, wg *sync.WaitGroup, plock *sync.Mutex, sem chan struct{}) {
-	defer wg.Done()
+// searchFile searches one file for m, or (when m is nil) matches fprx
+// against the path itself. The caller is responsible for bounding how
+// many searchFile calls run concurrently, including how many have a file
+// open at once; see pool.
+func searchFile(path string, m matcher, fprx *regexp.Regexp, sink OutputSink) {
 	if fprx != nil && !fprx.MatchString(path) {
 		return
 	}
-	if rx == nil {
+	if m == nil {
 		ms := fprx.FindAllStringIndex(path, -1)
-		last := 0
-		plock.Lock()
-		defer plock.Unlock()
-		for _, m := range ms {
-			l, r := m[0], m[1]
-			fmt.Printf("%s%s", path[last:l], colors.Bold(colors.Blue(path[l:r])))
-			last = r
-		}
-		fmt.Printf("%s\n", path[last:])
+		records := make([]matchRecord, 0, len(ms))
+		for _, mi := range ms {
+			l, r := mi[0], mi[1]
+			records = append(records, matchRecord{
+				Path:          path,
+				Col:           l + 1,
+				Match:         path[l:r],
+				Preview:       path,
+				previewOffset: l,
+				pathOnly:      true,
+			})
+		}
+		sink.WriteFile(path, records)
 		return
 	}
-	sem <- struct{}{}
-	defer func(sem chan struct{}) {
-		<-sem
-	}(sem)
 
-	var f *os.File
-	var err error
-	for {
-		f, err = os.Open(path)
-		if err != nil {
-			if !tooManyOpen.MatchString(err.Error()) {
-				log.Println(err)
-				return
-			}
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-		break
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println(err)
+		return
 	}
 	defer f.Close()
-	grepReader(path, f, rx, plock)
+
+	if *binaryMode == "text" {
+		grepReader(path, f, m, sink, false)
+		return
+	}
+
+	sample := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, sample)
+	sample = sample[:n]
+
+	binarySummary := false
+	if isBinary(sample) {
+		if *binaryMode == "skip" {
+			return
+		}
+		binarySummary = true
+	}
+	grepReader(path, io.MultiReader(bytes.NewReader(sample), f), m, sink, binarySummary)
 }
 
 func formatHeader(path string, num int) string {
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// captureSink records every WriteFile call it receives, for tests that
+// need to inspect what a grepReader run would have rendered.
+type captureSink struct {
+	calls [][]matchRecord
+}
+
+func (s *captureSink) WriteFile(path string, records []matchRecord) {
+	s.calls = append(s.calls, records)
+}
+
+func (s *captureSink) Close() {}
+
+// A binary file whose first "line" (no '\n' for a long stretch) exceeds
+// --max-line-length must still collapse to the single binary-summary
+// record, not the raw truncated bytes: the truncated branch has to check
+// binarySummary before building any other kind of record.
+func TestGrepReaderBinaryTruncatedLineSummarizes(t *testing.T) {
+	data := append([]byte{0, 0, 0}, bytes.Repeat([]byte{'x'}, *maxLineLength+1000)...)
+	m := newRegexMatcher(regexp.MustCompile("(?i)x"))
+	sink := &captureSink{}
+
+	grepReader("bin.dat", bytes.NewReader(data), m, sink, true)
+
+	if len(sink.calls) != 1 || len(sink.calls[0]) != 1 {
+		t.Fatalf("got %d WriteFile calls, want exactly 1 with 1 record: %+v", len(sink.calls), sink.calls)
+	}
+	r := sink.calls[0][0]
+	if !r.binary {
+		t.Fatalf("record = %+v, want a binary summary record", r)
+	}
+	if !strings.Contains(r.Preview, "binary file") {
+		t.Errorf("Preview = %q, want it to read as a binary-file summary", r.Preview)
+	}
+	if strings.ContainsAny(r.Preview, "\x00\x01\x02") {
+		t.Errorf("Preview = %q, leaked raw binary bytes instead of summarizing", r.Preview)
+	}
+}
+
+// The non-binary truncated-line path is unaffected: a long matching line
+// in a text file still reports a truncated preview, not a binary summary.
+func TestGrepReaderTextTruncatedLineReportsTruncation(t *testing.T) {
+	data := append([]byte{'x'}, append(bytes.Repeat([]byte{'a'}, *maxLineLength+100), '\n')...)
+	m := newRegexMatcher(regexp.MustCompile("(?i)x"))
+	sink := &captureSink{}
+
+	grepReader("big.txt", bytes.NewReader(data), m, sink, false)
+
+	if len(sink.calls) != 1 || len(sink.calls[0]) != 1 {
+		t.Fatalf("got %d WriteFile calls, want exactly 1 with 1 record: %+v", len(sink.calls), sink.calls)
+	}
+	r := sink.calls[0][0]
+	if r.binary {
+		t.Fatalf("record = %+v, want a truncated-line record, not a binary summary", r)
+	}
+	if !strings.Contains(r.Preview, "truncated") {
+		t.Errorf("Preview = %q, want it to mention truncation", r.Preview)
+	}
+}
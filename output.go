@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// matchRecord is one reported match. Path/Line/Col/Match/Preview are the
+// fields documented for --output=json/jsonl; previewOffset is unexported
+// (and so never marshaled) and exists only so renderHuman can find Match
+// inside Preview without re-searching for it.
+type matchRecord struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Match   string `json:"match"`
+	Preview string `json:"preview"`
+
+	previewOffset int
+	pathOnly      bool // true for an -f filename match, not a content match
+	binary        bool // true for a "binary file matches" summary record
+}
+
+// OutputSink owns stdout for the duration of the run and renders the
+// matches found in each file. WriteFile is called once per file with
+// every match found in it (and never called for a file with no matches);
+// Close must be called after every producer is done and blocks until
+// anything buffered has been flushed.
+type OutputSink interface {
+	WriteFile(path string, records []matchRecord)
+	Close()
+}
+
+// newOutputSink builds the sink for the given --output value. "" selects
+// the default colored, human-readable format.
+func newOutputSink(mode string) (OutputSink, error) {
+	switch mode {
+	case "":
+		return newChanSink(renderHuman), nil
+	case "grep":
+		return newChanSink(renderGrep), nil
+	case "jsonl":
+		return newChanSink(renderJSONL), nil
+	case "json":
+		return newJSONSink(), nil
+	case "null":
+		return nullSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -output mode %q (want grep, json, jsonl or null)", mode)
+	}
+}
+
+// fileBatch is one WriteFile call queued for the owning goroutine.
+type fileBatch struct {
+	path    string
+	records []matchRecord
+}
+
+// chanSink funnels every WriteFile call through a single goroutine via a
+// channel, so whichever render func it's constructed with can write to
+// stdout without its own locking and without interleaving with other
+// files' output.
+type chanSink struct {
+	ch   chan fileBatch
+	done chan struct{}
+}
+
+func newChanSink(render func(path string, records []matchRecord)) *chanSink {
+	s := &chanSink{ch: make(chan fileBatch, 64), done: make(chan struct{})}
+	go func() {
+		for b := range s.ch {
+			render(b.path, b.records)
+		}
+		close(s.done)
+	}()
+	return s
+}
+
+func (s *chanSink) WriteFile(path string, records []matchRecord) {
+	if len(records) == 0 {
+		return
+	}
+	s.ch <- fileBatch{path, records}
+}
+
+func (s *chanSink) Close() {
+	close(s.ch)
+	<-s.done
+}
+
+// renderHuman reproduces cgrep's original colored terminal format: a
+// green header with the match count, then one line per match with the
+// matched text bolded.
+func renderHuman(path string, records []matchRecord) {
+	if records[0].binary {
+		fmt.Printf("%s\n", colors.Green(records[0].Preview))
+		return
+	}
+	if records[0].pathOnly {
+		renderPathOnlyHuman(path, records)
+		return
+	}
+	fmt.Print(formatHeader(path, len(records)))
+	for _, r := range records {
+		fmt.Print(formatLine([]byte(r.Preview), r.previewOffset, r.previewOffset+len(r.Match), r.Line, 0) + "\n")
+	}
+}
+
+// renderPathOnlyHuman reproduces the single highlighted-path line cgrep
+// printed before structured output existed, for an -f match with no
+// content pattern: every match on the path is bolded inline on one line.
+func renderPathOnlyHuman(path string, records []matchRecord) {
+	last := 0
+	for _, r := range records {
+		l, rt := r.previewOffset, r.previewOffset+len(r.Match)
+		fmt.Printf("%s%s", path[last:l], colors.Bold(colors.Blue(path[l:rt])))
+		last = rt
+	}
+	fmt.Printf("%s\n", path[last:])
+}
+
+// renderGrep emits the classic grep-compatible path:line:col:text format,
+// uncolored, so output can feed quickfix lists, fzf --preview, etc.
+func renderGrep(path string, records []matchRecord) {
+	if records[0].binary {
+		fmt.Println(records[0].Preview)
+		return
+	}
+	if records[0].pathOnly {
+		fmt.Println(path)
+		return
+	}
+	for _, r := range records {
+		fmt.Printf("%s:%d:%d:%s\n", path, r.Line, r.Col, r.Preview)
+	}
+}
+
+// renderJSONL emits one JSON object per match, one per line.
+func renderJSONL(path string, records []matchRecord) {
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		fmt.Println(string(b))
+	}
+}
+
+// jsonSink collects every match across the whole run and prints a single
+// JSON array on Close, once every producer has finished.
+type jsonSink struct {
+	*chanSink
+	all []matchRecord
+}
+
+func newJSONSink() *jsonSink {
+	s := &jsonSink{all: []matchRecord{}}
+	// Every call to render below runs on chanSink's single owning
+	// goroutine, so appending to s.all needs no extra synchronization.
+	s.chanSink = newChanSink(func(path string, records []matchRecord) {
+		s.all = append(s.all, records...)
+	})
+	return s
+}
+
+func (s *jsonSink) Close() {
+	s.chanSink.Close()
+	b, err := json.MarshalIndent(s.all, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Println(string(b))
+}
+
+// nullSink discards everything; useful for benchmarking search speed
+// without the cost of formatting or writing output.
+type nullSink struct{}
+
+func (nullSink) WriteFile(string, []matchRecord) {}
+func (nullSink) Close()                          {}
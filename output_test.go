@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout redirects os.Stdout for the duration of fn and returns
+// everything written to it.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	fn()
+
+	w.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestGrepSinkFormat(t *testing.T) {
+	sink := newChanSink(renderGrep)
+	records := []matchRecord{{Path: "f.go", Line: 3, Col: 5, Match: "foo", Preview: "var foo = 1"}}
+
+	out := captureStdout(t, func() {
+		sink.WriteFile("f.go", records)
+		sink.Close()
+	})
+
+	want := "f.go:3:5:var foo = 1\n"
+	if out != want {
+		t.Errorf("renderGrep output = %q, want %q", out, want)
+	}
+}
+
+func TestJSONLSinkFormat(t *testing.T) {
+	sink := newChanSink(renderJSONL)
+	records := []matchRecord{
+		{Path: "f.go", Line: 1, Col: 1, Match: "a", Preview: "a"},
+		{Path: "f.go", Line: 2, Col: 1, Match: "b", Preview: "b"},
+	}
+
+	out := captureStdout(t, func() {
+		sink.WriteFile("f.go", records)
+		sink.Close()
+	})
+
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), out)
+	}
+	var rec matchRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("line 1 isn't valid JSON: %v", err)
+	}
+	if rec.Path != "f.go" || rec.Match != "a" {
+		t.Errorf("decoded %+v, want Path=f.go Match=a", rec)
+	}
+}
+
+func TestJSONSinkEmitsSingleArray(t *testing.T) {
+	sink := newJSONSink()
+
+	out := captureStdout(t, func() {
+		sink.WriteFile("a.go", []matchRecord{{Path: "a.go", Line: 1, Match: "x"}})
+		sink.WriteFile("b.go", []matchRecord{{Path: "b.go", Line: 2, Match: "y"}})
+		sink.Close()
+	})
+
+	var records []matchRecord
+	if err := json.Unmarshal([]byte(out), &records); err != nil {
+		t.Fatalf("output isn't a single JSON array: %v\noutput: %s", err, out)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+}
+
+func TestNullSinkWritesNothing(t *testing.T) {
+	sink := nullSink{}
+	out := captureStdout(t, func() {
+		sink.WriteFile("f.go", []matchRecord{{Path: "f.go", Match: "x"}})
+		sink.Close()
+	})
+	if out != "" {
+		t.Errorf("nullSink wrote %q, want nothing", out)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+
+	"github.com/rednexela1941/cgrep/internal/ignore"
+)
+
+// workItem is one file queued up for searchFile.
+type workItem struct {
+	path string
+}
+
+// pool is cgrep's bounded worker pool: a fixed number of goroutines pull
+// file work items from a single channel, fed by one dedicated walker
+// goroutine that recurses through the directory tree itself. This
+// replaces the old one-goroutine-per-directory-and-per-file fan-out,
+// which could spin up hundreds of thousands of goroutines on a large
+// tree and made "too many open files" a routine occurrence rather than
+// a backstop. Keeping the walker as the sole producer also means the
+// pool's fixed consumers can never all be blocked trying to produce at
+// once — unlike a design where workers both enqueue and dequeue the same
+// channel, which can deadlock once every worker is parked mid-enqueue.
+type pool struct {
+	items          chan workItem
+	wg             sync.WaitGroup
+	openFiles      chan struct{}
+	m              matcher
+	fprx           *regexp.Regexp
+	sink           OutputSink
+	incExt, excExt extSet
+}
+
+// newPool starts workers goroutines (runtime.NumCPU() if workers <= 0)
+// pulling from a shared work queue, bounding concurrently open files to
+// openFileLimit.
+func newPool(workers, openFileLimit int, m matcher, fprx *regexp.Regexp, sink OutputSink, incExt, excExt extSet) *pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &pool{
+		items:     make(chan workItem, 4096),
+		openFiles: make(chan struct{}, openFileLimit),
+		m:         m,
+		fprx:      fprx,
+		sink:      sink,
+		incExt:    incExt,
+		excExt:    excExt,
+	}
+	for i := 0; i < workers; i++ {
+		go p.run()
+	}
+	return p
+}
+
+func (p *pool) run() {
+	for item := range p.items {
+		p.openFiles <- struct{}{}
+		searchFile(item.path, p.m, p.fprx, p.sink)
+		<-p.openFiles
+		p.wg.Done()
+	}
+}
+
+func (p *pool) enqueue(path string) {
+	p.wg.Add(1)
+	p.items <- workItem{path: path}
+}
+
+// Walk recurses through root on a single dedicated walker goroutine,
+// enqueueing files for the worker pool to search, and blocks until the
+// walk and every file it scheduled have finished.
+func (p *pool) Walk(root string, stack ignore.Stack) {
+	done := make(chan struct{})
+	go func() {
+		p.walkDir(root, stack)
+		close(done)
+	}()
+	<-done
+	p.wg.Wait()
+}
+
+// SearchFiles enqueues paths directly as file work with no directory
+// walking (e.g. the candidate set from a trigram index query) and blocks
+// until they've all been searched.
+func (p *pool) SearchFiles(paths []string) {
+	for _, path := range paths {
+		if p.fprx != nil && !p.fprx.MatchString(path) {
+			continue
+		}
+		p.enqueue(path)
+	}
+	p.wg.Wait()
+}
+
+// Close shuts down the pool's workers. Call it only after Walk/
+// SearchFiles has returned.
+func (p *pool) Close() {
+	close(p.items)
+}
+
+// walkDir recurses into dir on the calling goroutine, enqueueing regular
+// files that pass the ignore stack and extension filters as work for the
+// pool's workers. It's the sole producer onto p.items: because it never
+// also drains that channel, a full buffer just blocks the walker, never
+// every worker at once, so the pool can't deadlock the way a design
+// where workers both enqueue and dequeue the same channel can.
+func (p *pool) walkDir(dir string, stack ignore.Stack) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			dirSet, err := ignore.LoadDir(path)
+			if err != nil {
+				log.Println(err)
+			}
+			dirStack := stack.Push(dirSet)
+			if dirStack.Ignored(path, true) {
+				continue
+			}
+			p.walkDir(path, dirStack)
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if stack.Ignored(path, false) || !extAllowed(path, p.incExt, p.excExt) {
+			continue
+		}
+		if !*long && info.Size() > longFileLim && (p.fprx == nil || p.fprx.MatchString(path)) {
+			// Informational, not a match: goes to stderr via log like the
+			// walker's other diagnostics, so --output=json/jsonl stdout
+			// stays parseable.
+			log.Printf("skipping large file %s", path)
+			continue
+		}
+		p.enqueue(path)
+	}
+}
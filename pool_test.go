@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestPoolWideTreeNoDeadlock drives the pool over a tree wide enough that
+// the enqueue calls for its directories together far exceed the items
+// channel's buffer (8×3000 files against 4096 slots). With workers also
+// acting as the channel's producers (the pre-fix design) this reliably deadlocks once
+// every worker is parked inside an enqueue; here it must just finish.
+func TestPoolWideTreeNoDeadlock(t *testing.T) {
+	root := t.TempDir()
+	const dirs, filesPerDir = 8, 3000
+	for d := 0; d < dirs; d++ {
+		sub := filepath.Join(root, "d"+strconv.Itoa(d))
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			path := filepath.Join(sub, "f"+strconv.Itoa(f)+".txt")
+			if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	m := newRegexMatcher(regexp.MustCompile("(?i)x"))
+	p := newPool(8, 64, m, nil, nullSink{}, nil, nil)
+	defer p.Close()
+
+	done := make(chan struct{})
+	go func() {
+		p.Walk(root, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pool.Walk did not return, likely deadlocked")
+	}
+}
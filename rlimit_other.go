@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// openFileUlimit has no portable way to read the process's file
+// descriptor limit outside unix, so it just returns fallback.
+func openFileUlimit(fallback int) int {
+	return fallback
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// reservedFDs are held back from the pool for cgrep's other descriptors
+// (stdio, the index file, etc.) so the pool's bounded semaphore stays
+// comfortably under the process's actual file descriptor ceiling.
+const reservedFDs = 32
+
+// openFileUlimit reports how many files cgrep should allow its worker
+// pool to hold open concurrently, derived from RLIMIT_NOFILE. It falls
+// back to fallback if the limit can't be read or is too small to bother
+// subtracting reservedFDs from.
+func openFileUlimit(fallback int) int {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return fallback
+	}
+	limit := int(rlimit.Cur)
+	if limit <= reservedFDs {
+		return fallback
+	}
+	return limit - reservedFDs
+}